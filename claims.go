@@ -0,0 +1,171 @@
+package passwordreset
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+)
+
+// NewTokenWithClaims is like NewToken, but additionally binds a set of
+// application-defined string claims to the token, signed together with the
+// rest of the token data. Typical uses are tying a reset token to the email
+// address it was sent to, or to the action it authorizes (e.g.
+// claims["purpose"] = "reset-password"), so that a token minted for one flow
+// can't be replayed against a different endpoint that shares the same
+// secret.
+//
+// Like NewToken, the token uses the V1 (4-byte, Y2106-limited) expiration
+// format. NewTokenV2 has no claims-carrying counterpart, and
+// VerifyTokenWithClaims only reads the 4-byte expiration format.
+func NewTokenWithClaims(login string, dur time.Duration, claims map[string]string, pwdval, secret []byte) string {
+	sk := getUserSecretKey(pwdval, secret)
+	data := make([]byte, 0, 4+2+len(login)+claimsLen(claims))
+	data = append(data, encodeExpiration(time.Now().Add(dur))...)
+	data = appendUint16Prefixed(data, []byte(login))
+	data = appendClaims(data, claims)
+	sig := getSignature(data, sk)
+	return encodeToken(append(data, sig...))
+}
+
+// VerifyTokenWithClaims verifies the given token the same way VerifyToken
+// does, and additionally returns the claims bound to it by
+// NewTokenWithClaims. token must have been created by NewTokenWithClaims;
+// VerifyTokenWithClaims does not accept tokens created by the plain
+// NewToken.
+func VerifyTokenWithClaims(token string, pwdvalFn func(string) ([]byte, error), secret []byte) (login string, claims map[string]string, err error) {
+	b, err := decodeToken(token)
+	if err != nil {
+		return
+	}
+	if len(b) <= 4+32 {
+		err = ErrMalformedToken
+		return
+	}
+	data := b[:len(b)-32]
+	sig := b[len(b)-32:]
+
+	exp := time.Unix(int64(beUint32(data[:4])), 0)
+	if exp.Before(time.Now()) {
+		err = ErrExpiredToken
+		return
+	}
+
+	loginBytes, claimsBytes, err := readUint16Prefixed(data[4:])
+	if err != nil {
+		return
+	}
+	login = string(loginBytes)
+
+	pwdval, err := pwdvalFn(login)
+	if err != nil {
+		login = ""
+		return
+	}
+	sk := getUserSecretKey(pwdval, secret)
+	realSig := getSignature(data, sk)
+	if !constantTimeEqual(realSig, sig) {
+		login = ""
+		err = ErrWrongSignature
+		return
+	}
+
+	// Only parse claims, including the map allocation sized off the
+	// attacker-controlled count field, once the signature above has
+	// confirmed data hasn't been forged.
+	claims, err = decodeClaims(claimsBytes)
+	if err != nil {
+		login = ""
+		return
+	}
+	return
+}
+
+// claimsLen estimates the encoded size of claims, to preallocate data's
+// backing array; it doesn't need to be exact.
+func claimsLen(claims map[string]string) int {
+	n := 2
+	for k, v := range claims {
+		n += 2 + len(k) + 2 + len(v)
+	}
+	return n
+}
+
+// appendClaims appends claims, sorted by key for deterministic signing, to b
+// as a count-prefixed sequence of length-prefixed key/value pairs.
+func appendClaims(b []byte, claims map[string]string) []byte {
+	keys := make([]string, 0, len(claims))
+	for k := range claims {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	countBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(countBuf, uint16(len(keys)))
+	b = append(b, countBuf...)
+	for _, k := range keys {
+		b = appendUint16Prefixed(b, []byte(k))
+		b = appendUint16Prefixed(b, []byte(claims[k]))
+	}
+	return b
+}
+
+// decodeClaims parses the count-prefixed claims block produced by
+// appendClaims.
+func decodeClaims(b []byte) (map[string]string, error) {
+	if len(b) < 2 {
+		return nil, ErrMalformedToken
+	}
+	count := binary.BigEndian.Uint16(b[:2])
+	b = b[2:]
+	// Each entry needs at least 4 bytes (empty key and value length
+	// prefixes); cap the preallocation so a claimed count far larger than
+	// what's actually present can't force an oversized map. The read loop
+	// below still catches a bogus count with its own bounds checks.
+	prealloc := int(count)
+	if max := len(b) / 4; prealloc > max {
+		prealloc = max
+	}
+
+	claims := make(map[string]string, prealloc)
+	for i := 0; i < int(count); i++ {
+		key, rest, err := readUint16Prefixed(b)
+		if err != nil {
+			return nil, err
+		}
+		val, rest, err := readUint16Prefixed(rest)
+		if err != nil {
+			return nil, err
+		}
+		claims[string(key)] = string(val)
+		b = rest
+	}
+	if len(b) != 0 {
+		return nil, ErrMalformedToken
+	}
+	return claims, nil
+}
+
+// appendUint16Prefixed appends v to b, preceded by its length as a 2-byte
+// big-endian integer.
+func appendUint16Prefixed(b, v []byte) []byte {
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, uint16(len(v)))
+	b = append(b, lenBuf...)
+	return append(b, v...)
+}
+
+// readUint16Prefixed reads a 2-byte-length-prefixed value off the front of
+// b, returning the value and the remaining bytes.
+func readUint16Prefixed(b []byte) (v, rest []byte, err error) {
+	if len(b) < 2 {
+		err = ErrMalformedToken
+		return
+	}
+	n := int(binary.BigEndian.Uint16(b[:2]))
+	b = b[2:]
+	if len(b) < n {
+		err = ErrMalformedToken
+		return
+	}
+	return b[:n], b[n:], nil
+}
@@ -0,0 +1,57 @@
+package passwordreset
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyTokenWithClaims(t *testing.T) {
+	claims := map[string]string{
+		"purpose": "reset-password",
+		"email":   "user@example.com",
+	}
+	token := NewTokenWithClaims(testLogin, time.Hour, claims, testPwdVar, testSecret)
+
+	login, gotClaims, err := VerifyTokenWithClaims(token, getPwdVal, testSecret)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if login != testLogin {
+		t.Errorf("login: expected %q, got %q", testLogin, login)
+	}
+	for k, v := range claims {
+		if gotClaims[k] != v {
+			t.Errorf("claims[%q]: expected %q, got %q", k, v, gotClaims[k])
+		}
+	}
+}
+
+func TestVerifyTokenWithClaimsRejectsForgedCount(t *testing.T) {
+	// A forged token with a huge claimed claims count but no signature
+	// matching any real key must fail on the signature check, without
+	// ever trusting the count enough to allocate off it.
+	data := make([]byte, 0, 4+2+len(testLogin)+2)
+	data = append(data, encodeExpiration(time.Now().Add(time.Hour))...)
+	data = appendUint16Prefixed(data, []byte(testLogin))
+	countBuf := []byte{0xff, 0xff} // claims count = 65535, no entries follow
+	data = append(data, countBuf...)
+	forged := encodeToken(append(data, make([]byte, 32)...))
+
+	if _, _, err := VerifyTokenWithClaims(forged, getPwdVal, testSecret); err != ErrWrongSignature {
+		t.Errorf("expected ErrWrongSignature, got %v", err)
+	}
+}
+
+func TestVerifyTokenWithClaimsNoClaims(t *testing.T) {
+	token := NewTokenWithClaims(testLogin, time.Hour, nil, testPwdVar, testSecret)
+	login, claims, err := VerifyTokenWithClaims(token, getPwdVal, testSecret)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if login != testLogin {
+		t.Errorf("login: expected %q, got %q", testLogin, login)
+	}
+	if len(claims) != 0 {
+		t.Errorf("expected no claims, got %v", claims)
+	}
+}
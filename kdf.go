@@ -0,0 +1,79 @@
+package passwordreset
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// KeyDeriver derives the per-user secret key used to sign and verify
+// password reset tokens from a password value and an application secret
+// key. ID identifies the deriver inside V3 tokens (see
+// NewTokenWithDeriver), so VerifyToken knows which KeyDeriver to run instead
+// of having to guess.
+type KeyDeriver interface {
+	// DeriveUserKey returns the per-user signing key for pwdval and secret.
+	DeriveUserKey(pwdval, secret []byte) []byte
+	// ID returns the one-byte identifier embedded in tokens created with
+	// this deriver. It must be stable and unique among registered derivers.
+	ID() byte
+}
+
+// HMACSHA256Deriver is the original key derivation used by NewToken:
+// HMAC-SHA256(pwdval, secret). It's registered under ID 1.
+type HMACSHA256Deriver struct{}
+
+// ID implements KeyDeriver.
+func (HMACSHA256Deriver) ID() byte { return 1 }
+
+// DeriveUserKey implements KeyDeriver.
+func (HMACSHA256Deriver) DeriveUserKey(pwdval, secret []byte) []byte {
+	return getUserSecretKey(pwdval, secret)
+}
+
+// Argon2idDeriver derives the per-user signing key with Argon2id instead of
+// a single round of HMAC-SHA256. Use it when pwdval is low-entropy (a short
+// salt, a user id, or a password creation timestamp, rather than a full
+// password hash), which makes the plain HMAC-SHA256 construction weaker than
+// it should be. It's registered under ID 2.
+type Argon2idDeriver struct {
+	Time, Memory, Threads uint32
+}
+
+// ID implements KeyDeriver.
+func (Argon2idDeriver) ID() byte { return 2 }
+
+// DeriveUserKey implements KeyDeriver.
+func (d Argon2idDeriver) DeriveUserKey(pwdval, secret []byte) []byte {
+	return argon2.IDKey(pwdval, secret, d.Time, d.Memory, uint8(d.Threads), 32)
+}
+
+// DefaultDeriver is the KeyDeriver NewTokenWithDeriver's callers should pass
+// when they don't need a non-default one. Swapping it for an Argon2idDeriver
+// also requires RegisterKeyDeriver, so VerifyToken can look it up by ID.
+var DefaultDeriver KeyDeriver = HMACSHA256Deriver{}
+
+var (
+	keyDeriversMu sync.RWMutex
+	keyDerivers   = map[byte]KeyDeriver{
+		1: HMACSHA256Deriver{},
+		2: Argon2idDeriver{Time: 1, Memory: 64 * 1024, Threads: 4},
+	}
+)
+
+// RegisterKeyDeriver makes d available to VerifyToken for V3 tokens created
+// by NewTokenWithDeriver(..., d). Call it with exactly the parameters used
+// to create such tokens, before any of them are verified. Registering a
+// deriver under an ID that's already taken replaces the previous one.
+func RegisterKeyDeriver(d KeyDeriver) {
+	keyDeriversMu.Lock()
+	defer keyDeriversMu.Unlock()
+	keyDerivers[d.ID()] = d
+}
+
+func lookupKeyDeriver(id byte) (KeyDeriver, bool) {
+	keyDeriversMu.RLock()
+	defer keyDeriversMu.RUnlock()
+	d, ok := keyDerivers[id]
+	return d, ok
+}
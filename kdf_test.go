@@ -0,0 +1,31 @@
+package passwordreset
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewTokenWithDeriverHMAC(t *testing.T) {
+	token := NewTokenWithDeriver(testLogin, time.Hour, testPwdVar, testSecret, HMACSHA256Deriver{})
+	login, err := VerifyToken(token, getPwdVal, testSecret)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if login != testLogin {
+		t.Errorf("login: expected %q, got %q", testLogin, login)
+	}
+}
+
+func TestNewTokenWithDeriverArgon2id(t *testing.T) {
+	deriver := Argon2idDeriver{Time: 1, Memory: 64 * 1024, Threads: 4}
+	RegisterKeyDeriver(deriver)
+
+	token := NewTokenWithDeriver(testLogin, time.Hour, testPwdVar, testSecret, deriver)
+	login, err := VerifyToken(token, getPwdVal, testSecret)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if login != testLogin {
+		t.Errorf("login: expected %q, got %q", testLogin, login)
+	}
+}
@@ -0,0 +1,39 @@
+package passwordreset
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyTokenWithKeys(t *testing.T) {
+	oldSecret := []byte("old secret key")
+	newSecret := []byte("new secret key")
+	keys := [][]byte{newSecret, oldSecret}
+
+	// A token signed with the newest key verifies against the full list.
+	token := NewToken(testLogin, time.Hour, testPwdVar, newSecret)
+	login, err := VerifyTokenWithKeys(token, getPwdVal, keys)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if login != testLogin {
+		t.Errorf("login: expected %q, got %q", testLogin, login)
+	}
+
+	// A token signed with a rotated-out key still verifies as long as it's
+	// still in the list.
+	token = NewToken(testLogin, time.Hour, testPwdVar, oldSecret)
+	login, err = VerifyTokenWithKeys(token, getPwdVal, keys)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if login != testLogin {
+		t.Errorf("login: expected %q, got %q", testLogin, login)
+	}
+
+	// A token signed with a key that has been dropped entirely fails.
+	token = NewToken(testLogin, time.Hour, testPwdVar, []byte("dropped key"))
+	if _, err := VerifyTokenWithKeys(token, getPwdVal, keys); err != ErrWrongSignature {
+		t.Errorf("expected ErrWrongSignature, got %v", err)
+	}
+}
@@ -24,6 +24,10 @@
 // Password value is used to make tokens one-time, that is, once a user changes
 // their password, the token which they used to do a reset, becomes invalid.
 //
+// Because the 4-byte expiration time above rolls over in 2106, NewTokenV2
+// produces a V2 token instead, prefixing the data with a one-byte version tag
+// and widening the expiration time to 8 bytes. VerifyToken accepts tokens in
+// either format.
 //
 //
 // Usage example:
@@ -83,23 +87,43 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
-	"github.com/dchest/authcookie"
+	"strings"
 	"time"
+
+	"github.com/dchest/authcookie"
 )
 
-// MinTokenLength is the minimum allowed length of token string.
+// MinTokenLength is the minimum allowed length of a V1 token string, as
+// created by NewToken.
 //
 // It is useful for avoiding DoS attacks with very long tokens: before passing
 // a token to VerifyToken function, check that it has length less than [the
 // maximum login length allowed in your application] + MinTokenLength.
 var MinTokenLength = authcookie.MinLength
 
+// MinTokenLengthV2 is the minimum allowed length of a V2 token string, as
+// created by NewTokenV2: a 1-byte version tag, 8-byte expiration, 1-byte
+// login and 32-byte signature, base64-encoded.
+var MinTokenLengthV2 = base64.RawURLEncoding.EncodedLen(1 + 8 + 1 + 32)
+
 var (
 	ErrMalformedToken = errors.New("malformed token")
 	ErrExpiredToken   = errors.New("token expired")
 	ErrWrongSignature = errors.New("wrong token signature")
 )
 
+// v2Marker is the first byte of a V2 token's signed data. V1 tokens have no
+// such marker: their first 4 bytes are the big-endian Unix timestamp of
+// their expiration, which only reaches 0xff as its top byte for dates in
+// 2105-2106 — the Y2106 rollover V2 exists to avoid — so a real V1 token
+// can't be mistaken for a V2 one in practice.
+const v2Marker = 0xff
+
+// v3Marker is the first byte of a V3 token's signed data (see
+// NewTokenWithDeriver), followed by a one-byte KeyDeriver ID and then an
+// 8-byte expiration like V2.
+const v3Marker = 0xfe
+
 func getUserSecretKey(pwdval, secret []byte) []byte {
 	m := hmac.New(sha256.New, secret)
 	m.Write(pwdval)
@@ -129,56 +153,181 @@ func NewTokenNoPadding(login string, dur time.Duration, pwdval, secret []byte) s
 	return authcookie.NewSinceNowNoPadding(login, dur, sk)
 }
 
-// VerifyToken verifies the given token with the password value returned by the
-// given function and the given secret key, and returns login extracted from
-// the valid token. If the token is not valid, the function returns an error.
-//
-// Function pwdvalFn must return the current password value for the login it
-// receives in arguments, or an error. If it returns an error, VerifyToken
-// returns the same error.
-func VerifyToken(token string, pwdvalFn func(string) ([]byte, error), secret []byte) (login string, err error) {
+// NewTokenV2 is like NewToken, but encodes the expiration time as a 64-bit
+// value, avoiding the Y2106 rollover of the V1 format. VerifyToken accepts
+// tokens created by either NewToken or NewTokenV2.
+func NewTokenV2(login string, dur time.Duration, pwdval, secret []byte) string {
+	sk := getUserSecretKey(pwdval, secret)
+	data := make([]byte, 0, 1+8+len(login))
+	data = append(data, v2Marker)
+	expBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(expBuf, uint64(time.Now().Add(dur).Unix()))
+	data = append(data, expBuf...)
+	data = append(data, login...)
+	sig := getSignature(data, sk)
+	return encodeToken(append(data, sig...))
+}
+
+// NewTokenWithDeriver is like NewTokenV2, but derives the per-user signing
+// key with the given KeyDeriver. Its ID is embedded in the token so
+// VerifyToken knows which KeyDeriver to run; register a non-default deriver
+// with RegisterKeyDeriver before verifying tokens signed with it.
+func NewTokenWithDeriver(login string, dur time.Duration, pwdval, secret []byte, deriver KeyDeriver) string {
+	sk := deriver.DeriveUserKey(pwdval, secret)
+	data := make([]byte, 0, 2+8+len(login))
+	data = append(data, v3Marker, deriver.ID())
+	expBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(expBuf, uint64(time.Now().Add(dur).Unix()))
+	data = append(data, expBuf...)
+	data = append(data, login...)
+	sig := getSignature(data, sk)
+	return encodeToken(append(data, sig...))
+}
+
+// parsedToken holds the fields extracted from a token string before its
+// signature has been checked against any secret key.
+type parsedToken struct {
+	data    []byte // [version [|| deriver ID]] || expiration_time || login, everything the signature covers
+	exp     time.Time
+	login   string
+	sig     []byte
+	deriver KeyDeriver // KeyDeriver to use to turn pwdval+secret into a signing key
+}
+
+// decodeToken base64-decodes a token string, picking the padded or unpadded
+// URL encoding depending on whether the string looks padded.
+func decodeToken(token string) ([]byte, error) {
 	encoding := base64.RawURLEncoding
 	// If we have padding, use URLEncoding instead of RawURLEncoding.
-	if strings.LastIndexByte(cookie, '=') != -1 {
+	if strings.LastIndexByte(token, '=') != -1 {
 		encoding = base64.URLEncoding
 	}
 	blen := encoding.DecodedLen(len(token))
 	// Avoid allocation if the token is too short
 	if blen <= 4+32 {
-		err = ErrMalformedToken
-		return
+		return nil, ErrMalformedToken
 	}
 	b := make([]byte, blen)
-	blen, err = encoding.Decode(b, []byte(token))
+	blen, err := encoding.Decode(b, []byte(token))
 	if err != nil {
-		return
+		return nil, err
 	}
-	// Decoded length may be bifferent from max length, which
+	// Decoded length may be different from max length, which
 	// we allocated, so check it, and set new length for b
 	if blen <= 4+32 {
-		err = ErrMalformedToken
+		return nil, ErrMalformedToken
+	}
+	return b[:blen], nil
+}
+
+// encodeToken base64-encodes b into a token string, using the unpadded URL
+// encoding produced by NewTokenNoPadding and the nonce-based constructors.
+func encodeToken(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// encodeExpiration returns the 4-byte big-endian encoding of t as seconds
+// since the Unix epoch, as used in the V1 token format.
+func encodeExpiration(t time.Time) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()))
+	return b
+}
+
+func beUint32(b []byte) uint32 {
+	return binary.BigEndian.Uint32(b)
+}
+
+func constantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// parseToken decodes the given token string and splits it into its
+// expiration time, login, signature and KeyDeriver, without checking the
+// signature against any secret key. It transparently handles the V1 format
+// (as created by NewToken), the V2 format (as created by NewTokenV2), and
+// the V3 format (as created by NewTokenWithDeriver).
+func parseToken(token string) (pt parsedToken, err error) {
+	b, err := decodeToken(token)
+	if err != nil {
 		return
 	}
-	b = b[:blen]
+	pt.deriver = HMACSHA256Deriver{}
+
+	if b[0] == v3Marker && len(b) > 2+8+32 {
+		d, ok := lookupKeyDeriver(b[1])
+		if !ok {
+			err = ErrMalformedToken
+			return
+		}
+		pt.deriver = d
+		pt.data = b[:len(b)-32]
+		pt.exp = time.Unix(int64(binary.BigEndian.Uint64(pt.data[2:10])), 0)
+		pt.login = string(pt.data[10:])
+		pt.sig = b[len(b)-32:]
+		return
+	}
+
+	if b[0] == v2Marker && len(b) > 1+8+32 {
+		pt.data = b[:len(b)-32]
+		pt.exp = time.Unix(int64(binary.BigEndian.Uint64(pt.data[1:9])), 0)
+		pt.login = string(pt.data[9:])
+		pt.sig = b[len(b)-32:]
+		return
+	}
+
+	pt.data = b[:len(b)-32]
+	pt.exp = time.Unix(int64(beUint32(pt.data[:4])), 0)
+	pt.login = string(pt.data[4:])
+	pt.sig = b[len(b)-32:]
+	return
+}
+
+// VerifyToken verifies the given token with the password value returned by the
+// given function and the given secret key, and returns login extracted from
+// the valid token. If the token is not valid, the function returns an error.
+//
+// Function pwdvalFn must return the current password value for the login it
+// receives in arguments, or an error. If it returns an error, VerifyToken
+// returns the same error.
+func VerifyToken(token string, pwdvalFn func(string) ([]byte, error), secret []byte) (login string, err error) {
+	return VerifyTokenWithKeys(token, pwdvalFn, [][]byte{secret})
+}
 
-	data := b[:blen-32]
-	exp := time.Unix(int64(binary.BigEndian.Uint32(data[:4])), 0)
-	if exp.Before(time.Now()) {
+// VerifyTokenWithKeys verifies the given token the same way VerifyToken does,
+// but against a list of candidate secret keys instead of a single one.
+//
+// This makes it possible to rotate an application's secret key without
+// invalidating reset tokens that are already out in users' inboxes: keep
+// signing new tokens with the newest key (pass it to NewToken), but verify
+// incoming tokens with VerifyTokenWithKeys and the full list of keys, newest
+// first, until every token signed with an older key has expired.
+//
+// Each key is tried in turn with a constant-time comparison; verification
+// succeeds as soon as one key produces a matching signature.
+func VerifyTokenWithKeys(token string, pwdvalFn func(string) ([]byte, error), keys [][]byte) (login string, err error) {
+	pt, err := parseToken(token)
+	if err != nil {
+		return
+	}
+	if pt.exp.Before(time.Now()) {
 		err = ErrExpiredToken
 		return
 	}
-	login = string(data[4:])
+	login = pt.login
 	pwdval, err := pwdvalFn(login)
 	if err != nil {
 		login = ""
 		return
 	}
-	sig := b[blen-32:]
-	sk := getUserSecretKey(pwdval, secret)
-	realSig := getSignature(data, sk)
-	if subtle.ConstantTimeCompare(realSig, sig) != 1 {
-		err = ErrWrongSignature
-		return
+	for _, secret := range keys {
+		sk := pt.deriver.DeriveUserKey(pwdval, secret)
+		realSig := getSignature(pt.data, sk)
+		if subtle.ConstantTimeCompare(realSig, pt.sig) == 1 {
+			return login, nil
+		}
 	}
+	login = ""
+	err = ErrWrongSignature
 	return
 }
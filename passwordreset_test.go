@@ -1,18 +1,19 @@
 package passwordreset
 
 import (
+	"errors"
 	"testing"
-	"os"
+	"time"
 )
 
 var (
 	testLogin      = "test user"
 	testPwdVar     = []byte("test password value")
 	testSecret     = []byte("secret key")
-	testLoginError = os.NewError("test error")
+	testLoginError = errors.New("test error")
 )
 
-func getPwdVal(login string) ([]byte, os.Error) {
+func getPwdVal(login string) ([]byte, error) {
 	if login == testLogin {
 		return testPwdVar, nil
 	}
@@ -22,7 +23,7 @@ func getPwdVal(login string) ([]byte, os.Error) {
 
 func TestNew(t *testing.T) {
 	pwdVal, _ := getPwdVal(testLogin)
-	token := NewToken(testLogin, 100, pwdVal, testSecret)
+	token := NewToken(testLogin, 100*time.Second, pwdVal, testSecret)
 	login, err := VerifyToken(token, getPwdVal, testSecret)
 	if err != nil {
 		t.Errorf("unexpected error %q", err)
@@ -44,7 +45,7 @@ func TestVerify(t *testing.T) {
 			t.Errorf(`%d: login for bad token: expected "", got %q`, i, login)
 		}
 		if err == nil {
-			t.Errorf("%d: expected error")
+			t.Errorf("%d: expected error", i)
 		}
 	}
 	// Test expiration
@@ -0,0 +1,123 @@
+package passwordreset
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is the error VerifyTokenThrottled returns for any
+// verification failure, so that a response built from it doesn't leak which
+// check failed. The specific cause is still reachable with errors.Is.
+var ErrInvalidToken = errors.New("invalid token")
+
+// ErrTooManyAttempts is wrapped by ErrInvalidToken when a Throttler locks a
+// login out.
+var ErrTooManyAttempts = errors.New("too many attempts")
+
+// Throttler is consulted by VerifyTokenThrottled before a token's signature
+// is checked, since VerifyToken otherwise calls pwdvalFn with whatever login
+// is encoded in the token, letting an attacker probe for valid logins with
+// forged tokens.
+type Throttler interface {
+	// Allow reports whether a verification attempt for login may proceed.
+	Allow(login string) error
+	// RecordFailure is called after a verification attempt for login has
+	// failed, whatever the cause.
+	RecordFailure(login string)
+	// RecordSuccess is called after a verification attempt for login has
+	// succeeded, and should clear any lockout state for it.
+	RecordSuccess(login string)
+}
+
+// VerifyTokenThrottled verifies the given token like VerifyToken, but first
+// consults throttler, and records the outcome with it afterwards. Every
+// failure, including a throttler lockout, is returned as ErrInvalidToken.
+func VerifyTokenThrottled(token string, pwdvalFn func(string) ([]byte, error), secret []byte, throttler Throttler) (login string, err error) {
+	pt, err := parseToken(token)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if err := throttler.Allow(pt.login); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	login, err = VerifyToken(token, pwdvalFn, secret)
+	if err != nil {
+		throttler.RecordFailure(pt.login)
+		return "", fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	throttler.RecordSuccess(login)
+	return login, nil
+}
+
+// MemoryThrottler is a Throttler backed by an in-memory per-login failure
+// counter, suitable for a single-process application. Once a login has
+// accrued maxFailures consecutive failed attempts, it is locked out for
+// cooldown; a successful attempt resets its counter.
+type MemoryThrottler struct {
+	maxFailures int
+	cooldown    time.Duration
+
+	mu    sync.Mutex
+	state map[string]*throttleState
+}
+
+type throttleState struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+// NewMemoryThrottler returns a MemoryThrottler that locks a login out for
+// cooldown after maxFailures consecutive failed verification attempts.
+func NewMemoryThrottler(maxFailures int, cooldown time.Duration) *MemoryThrottler {
+	return &MemoryThrottler{
+		maxFailures: maxFailures,
+		cooldown:    cooldown,
+		state:       make(map[string]*throttleState),
+	}
+}
+
+// Allow implements Throttler.
+func (t *MemoryThrottler) Allow(login string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.state[login]
+	if !ok {
+		return nil
+	}
+	if s.failures < t.maxFailures {
+		return nil
+	}
+	if time.Now().Before(s.lockedUntil) {
+		return ErrTooManyAttempts
+	}
+	// Cooldown has elapsed: give the login a fresh run of maxFailures
+	// instead of re-locking it on the very next failure.
+	s.failures = 0
+	return nil
+}
+
+// RecordFailure implements Throttler.
+func (t *MemoryThrottler) RecordFailure(login string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.state[login]
+	if !ok {
+		s = &throttleState{}
+		t.state[login] = s
+	}
+	s.failures++
+	if s.failures >= t.maxFailures {
+		s.lockedUntil = time.Now().Add(t.cooldown)
+	}
+}
+
+// RecordSuccess implements Throttler.
+func (t *MemoryThrottler) RecordSuccess(login string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.state, login)
+}
@@ -0,0 +1,61 @@
+package passwordreset
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyTokenThrottled(t *testing.T) {
+	throttler := NewMemoryThrottler(3, time.Minute)
+	token := NewToken(testLogin, time.Hour, testPwdVar, testSecret)
+
+	login, err := VerifyTokenThrottled(token, getPwdVal, testSecret, throttler)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if login != testLogin {
+		t.Errorf("login: expected %q, got %q", testLogin, login)
+	}
+}
+
+func TestVerifyTokenThrottledLockout(t *testing.T) {
+	throttler := NewMemoryThrottler(2, time.Minute)
+	bad := NewToken(testLogin, time.Hour, []byte("wrong value"), testSecret)
+
+	for i := 0; i < 2; i++ {
+		if _, err := VerifyTokenThrottled(bad, getPwdVal, testSecret, throttler); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("attempt %d: expected ErrInvalidToken, got %v", i, err)
+		}
+	}
+
+	// Third attempt should be rejected by the throttler itself, even with a
+	// token that would otherwise verify.
+	good := NewToken(testLogin, time.Hour, testPwdVar, testSecret)
+	if _, err := VerifyTokenThrottled(good, getPwdVal, testSecret, throttler); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("expected ErrTooManyAttempts, got %v", err)
+	}
+}
+
+func TestVerifyTokenThrottledUnwrapsCause(t *testing.T) {
+	throttler := NewMemoryThrottler(3, time.Minute)
+	token := NewToken(testLogin, -time.Hour, testPwdVar, testSecret)
+	if _, err := VerifyTokenThrottled(token, getPwdVal, testSecret, throttler); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("expected errors.Is(err, ErrExpiredToken), got %v", err)
+	}
+}
+
+func TestMemoryThrottlerResetsAfterCooldown(t *testing.T) {
+	throttler := NewMemoryThrottler(2, -time.Nanosecond) // cooldown already elapsed
+	throttler.RecordFailure(testLogin)
+	throttler.RecordFailure(testLogin)
+	if err := throttler.Allow(testLogin); err != nil {
+		t.Fatalf("expected lockout to have expired, got %v", err)
+	}
+	// A single further failure shouldn't immediately re-lock; the reset in
+	// Allow should have given the login a fresh run of maxFailures.
+	throttler.RecordFailure(testLogin)
+	if err := throttler.Allow(testLogin); err != nil {
+		t.Errorf("expected one failure after reset not to re-lock, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package passwordreset
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// nonceLen is the size, in bytes, of the random nonce embedded in tokens
+// created by NewTokenOnce.
+const nonceLen = 16
+
+// ErrTokenUsed is returned by VerifyTokenOnce when a token has already been
+// redeemed once before.
+var ErrTokenUsed = errors.New("token already used")
+
+// TokenStore tracks which tokens created by NewTokenOnce have already been
+// redeemed, so that VerifyTokenOnce can reject a token the second time it is
+// presented, even if it is still within its validity window.
+//
+// jti identifies a token (it is the hex encoding of its embedded nonce, which
+// is unique per token and covered by its signature). exp is the token's
+// expiration time; implementations may use it to evict entries once they can
+// no longer be presented as valid, instead of keeping them forever.
+//
+// MarkUsed must be atomic: two concurrent calls for the same jti must not
+// both report alreadyUsed == false, or the same token can be redeemed twice
+// in the race between them. A TokenStore can be backed by anything offering
+// that guarantee, for example Redis SET jti 1 EX <ttl> NX (checking the
+// reply), or a SQL INSERT into a table with a unique index on jti, treating
+// a constraint violation as alreadyUsed.
+type TokenStore interface {
+	// MarkUsed atomically checks whether jti has been used before and
+	// records that it has now, in a single step. It reports alreadyUsed ==
+	// true if and only if an earlier call already marked jti used.
+	MarkUsed(jti string, exp time.Time) (alreadyUsed bool, err error)
+}
+
+// NewTokenOnce is like NewToken, but embeds an additional random nonce in the
+// token, which VerifyTokenOnce uses together with a TokenStore to make the
+// token usable only once.
+func NewTokenOnce(login string, dur time.Duration, pwdval, secret []byte) (string, error) {
+	nonce := make([]byte, nonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sk := getUserSecretKey(pwdval, secret)
+	data := make([]byte, 0, 4+nonceLen+len(login))
+	data = append(data, encodeExpiration(time.Now().Add(dur))...)
+	data = append(data, nonce...)
+	data = append(data, login...)
+	sig := getSignature(data, sk)
+	return encodeToken(append(data, sig...)), nil
+}
+
+// VerifyTokenOnce verifies the given token the same way VerifyToken does, and
+// additionally consults store to make sure the token hasn't been redeemed
+// before: it is an error to present the same token (as created by
+// NewTokenOnce) twice, even if it hasn't expired yet.
+//
+// token must have been created by NewTokenOnce; VerifyTokenOnce does not
+// accept tokens created by the plain NewToken.
+func VerifyTokenOnce(token string, pwdvalFn func(string) ([]byte, error), secret []byte, store TokenStore) (login string, err error) {
+	b, err := decodeToken(token)
+	if err != nil {
+		return
+	}
+	if len(b) <= 4+nonceLen+32 {
+		err = ErrMalformedToken
+		return
+	}
+	data := b[:len(b)-32]
+	sig := b[len(b)-32:]
+
+	exp := time.Unix(int64(beUint32(data[:4])), 0)
+	if exp.Before(time.Now()) {
+		err = ErrExpiredToken
+		return
+	}
+	nonce := data[4 : 4+nonceLen]
+	login = string(data[4+nonceLen:])
+
+	pwdval, err := pwdvalFn(login)
+	if err != nil {
+		login = ""
+		return
+	}
+	sk := getUserSecretKey(pwdval, secret)
+	realSig := getSignature(data, sk)
+	if !constantTimeEqual(realSig, sig) {
+		login = ""
+		err = ErrWrongSignature
+		return
+	}
+
+	jti := hex.EncodeToString(nonce)
+	alreadyUsed, err := store.MarkUsed(jti, exp)
+	if err != nil {
+		login = ""
+		return
+	}
+	if alreadyUsed {
+		login = ""
+		err = ErrTokenUsed
+		return
+	}
+	return
+}
+
+// MemoryTokenStore is an in-memory TokenStore, suitable for a single-process
+// application or for tests. Used jti's are kept only until their token's
+// expiration time, after which they are evicted on the next call.
+//
+// For a multi-process deployment, back TokenStore with a shared store
+// instead, for example Redis (SET jti 1 EX <ttl> NX, checking the reply to
+// tell first-use from replay) or a SQL table keyed on jti with exp pruned by
+// a periodic DELETE WHERE exp < now().
+type MemoryTokenStore struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+// NewMemoryTokenStore returns a new, empty MemoryTokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{used: make(map[string]time.Time)}
+}
+
+// MarkUsed implements TokenStore.
+func (s *MemoryTokenStore) MarkUsed(jti string, exp time.Time) (alreadyUsed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictLocked()
+	if _, ok := s.used[jti]; ok {
+		return true, nil
+	}
+	s.used[jti] = exp
+	return false, nil
+}
+
+func (s *MemoryTokenStore) evictLocked() {
+	now := time.Now()
+	for jti, exp := range s.used {
+		if exp.Before(now) {
+			delete(s.used, jti)
+		}
+	}
+}
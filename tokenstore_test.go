@@ -0,0 +1,62 @@
+package passwordreset
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyTokenOnce(t *testing.T) {
+	store := NewMemoryTokenStore()
+	token, err := NewTokenOnce(testLogin, time.Hour, testPwdVar, testSecret)
+	if err != nil {
+		t.Fatalf("NewTokenOnce: unexpected error %q", err)
+	}
+
+	login, err := VerifyTokenOnce(token, getPwdVal, testSecret, store)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if login != testLogin {
+		t.Errorf("login: expected %q, got %q", testLogin, login)
+	}
+
+	// Replaying the same token must fail, even though it hasn't expired.
+	if _, err := VerifyTokenOnce(token, getPwdVal, testSecret, store); err != ErrTokenUsed {
+		t.Errorf("expected ErrTokenUsed, got %v", err)
+	}
+}
+
+func TestMemoryTokenStoreMarkUsedReportsReuse(t *testing.T) {
+	store := NewMemoryTokenStore()
+	exp := time.Now().Add(time.Hour)
+
+	alreadyUsed, err := store.MarkUsed("jti", exp)
+	if err != nil {
+		t.Fatalf("MarkUsed: unexpected error %q", err)
+	}
+	if alreadyUsed {
+		t.Fatalf("first MarkUsed reported alreadyUsed")
+	}
+
+	alreadyUsed, err = store.MarkUsed("jti", exp)
+	if err != nil {
+		t.Fatalf("MarkUsed: unexpected error %q", err)
+	}
+	if !alreadyUsed {
+		t.Errorf("second MarkUsed for the same jti should report alreadyUsed")
+	}
+}
+
+func TestMemoryTokenStoreEviction(t *testing.T) {
+	store := NewMemoryTokenStore()
+	if _, err := store.MarkUsed("jti", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("MarkUsed: unexpected error %q", err)
+	}
+	alreadyUsed, err := store.MarkUsed("jti", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("MarkUsed: unexpected error %q", err)
+	}
+	if alreadyUsed {
+		t.Errorf("expected expired jti to have been evicted, reported as already used")
+	}
+}
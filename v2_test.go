@@ -0,0 +1,24 @@
+package passwordreset
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewTokenV2(t *testing.T) {
+	token := NewTokenV2(testLogin, time.Hour, testPwdVar, testSecret)
+	login, err := VerifyToken(token, getPwdVal, testSecret)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if login != testLogin {
+		t.Errorf("login: expected %q, got %q", testLogin, login)
+	}
+}
+
+func TestNewTokenV2Expired(t *testing.T) {
+	token := NewTokenV2(testLogin, -time.Hour, testPwdVar, testSecret)
+	if _, err := VerifyToken(token, getPwdVal, testSecret); err != ErrExpiredToken {
+		t.Errorf("expected ErrExpiredToken, got %v", err)
+	}
+}